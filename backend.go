@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Backend is the storage transport for encrypted secrets. *git satisfies it
+// via ListRefs/WriteObject/CatFile/UpdateRef/RemoveRef/Sync below, so the
+// rest of carcosa (encrypt/decrypt/sync/list) can be written against Backend
+// and work unmodified whether the store is a git remote, a local directory,
+// or an object store such as S3 or GCS.
+type Backend interface {
+	ListRefs(ctx context.Context, namespace string) (refs, error)
+	WriteObject(ctx context.Context, data []byte) (string, error)
+	CatFile(ctx context.Context, hash string) ([]byte, error)
+	UpdateRef(ctx context.Context, refName string, pointer string) error
+	RemoveRef(ctx context.Context, refName string) error
+	Sync(ctx context.Context) error
+}
+
+// NewBackend resolves a `--store` value to a Backend implementation,
+// switching on the URL scheme: `s3://bucket/prefix` for S3, `gs://bucket/
+// prefix` for GCS, and anything else treated as a local filesystem path.
+// objectFormat ("sha1" or "sha256") should match the object format of the
+// carcosa namespace being read/written, so content hashes stay consistent
+// with *git's own addressing no matter which backend is in play.
+func NewBackend(store string, objectFormat string) (Backend, error) {
+	switch {
+	case strings.HasPrefix(store, "s3://"):
+		return newS3Backend(strings.TrimPrefix(store, "s3://"), objectFormat)
+	case strings.HasPrefix(store, "gs://"):
+		return newGCSBackend(strings.TrimPrefix(store, "gs://"), objectFormat)
+	default:
+		return newFilesystemBackend(strings.TrimPrefix(store, "file://"), objectFormat)
+	}
+}
+
+// hashContent addresses blob content the same way *git would for the given
+// object format: git hashes a blob header (`blob <len>\0`) prepended to the
+// content, not the bare bytes, so a content hash computed by a non-git
+// Backend lines up with what `git hash-object` would have produced for the
+// same bytes.
+func hashContent(objectFormat string, data []byte) string {
+	blob := append([]byte(fmt.Sprintf("blob %d\x00", len(data))), data...)
+
+	if objectFormat == "sha256" {
+		sum := sha256.Sum256(blob)
+		return hex.EncodeToString(sum[:])
+	}
+
+	sum := sha1.Sum(blob)
+	return hex.EncodeToString(sum[:])
+}
+
+// interface compliance checks, kept here so a signature change to Backend
+// fails the build for every implementation at once.
+var (
+	_ Backend = (*git)(nil)
+	_ Backend = (*filesystemBackend)(nil)
+	_ Backend = (*s3Backend)(nil)
+	_ Backend = (*gcsBackend)(nil)
+)
+
+// ListRefs satisfies Backend, delegating to the exec/native-backed
+// implementation in git.go.
+func (repo *git) ListRefs(ctx context.Context, namespace string) (refs, error) {
+	return repo.listRefs(ctx, namespace)
+}
+
+// WriteObject satisfies Backend.
+func (repo *git) WriteObject(ctx context.Context, data []byte) (string, error) {
+	return repo.writeObject(ctx, data)
+}
+
+// CatFile satisfies Backend.
+func (repo *git) CatFile(ctx context.Context, hash string) ([]byte, error) {
+	return repo.catFile(ctx, hash)
+}
+
+// UpdateRef satisfies Backend.
+func (repo *git) UpdateRef(ctx context.Context, refName string, pointer string) error {
+	return repo.updateRef(ctx, refName, pointer)
+}
+
+// RemoveRef satisfies Backend.
+func (repo *git) RemoveRef(ctx context.Context, refName string) error {
+	return repo.removeRef(ctx, refName)
+}
+
+// Sync is a no-op for the git backend: syncing a git-backed store is driven
+// explicitly via clone/fetch/push rather than an implicit reconciliation
+// step, unlike the object-store backends where Sync is what actually moves
+// bytes to/from the remote.
+func (repo *git) Sync(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reconquest/hierr-go"
+)
+
+// filesystemBackend stores encrypted blobs as plain files under a root
+// directory, keyed by the same content hash carcosa uses for git objects,
+// with refs kept as small files pointing at those hashes. It exists for
+// environments (CI runners, local testing) where a git server isn't
+// practical but a shared or mounted directory is available.
+type filesystemBackend struct {
+	root         string
+	objectFormat string
+}
+
+func newFilesystemBackend(root string, objectFormat string) (Backend, error) {
+	err := os.MkdirAll(filepath.Join(root, "objects"), 0755)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't create filesystem backend root: %s", root)
+	}
+
+	err = os.MkdirAll(filepath.Join(root, "refs"), 0755)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't create filesystem backend refs dir: %s", root)
+	}
+
+	return &filesystemBackend{root: root, objectFormat: objectFormat}, nil
+}
+
+func (backend *filesystemBackend) WriteObject(ctx context.Context, data []byte) (string, error) {
+	hash := hashContent(backend.objectFormat, data)
+
+	err := ioutil.WriteFile(filepath.Join(backend.root, "objects", hash), data, 0644)
+	if err != nil {
+		return "", hierr.Errorf(err, "can't write object %s to filesystem backend", hash)
+	}
+
+	return hash, nil
+}
+
+func (backend *filesystemBackend) CatFile(ctx context.Context, hash string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(backend.root, "objects", hash))
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read object %s from filesystem backend", hash)
+	}
+
+	return data, nil
+}
+
+func (backend *filesystemBackend) ListRefs(ctx context.Context, namespace string) (refs, error) {
+	refDir := filepath.Join(backend.root, "refs", namespace)
+
+	refList := refs{}
+
+	err := filepath.Walk(refDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(filepath.Join(backend.root, "refs"), path)
+		if err != nil {
+			return err
+		}
+
+		refList = append(refList, ref{
+			name: filepath.ToSlash(rel),
+			hash: strings.TrimSpace(string(data)),
+			stat: info,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't list refs under %s", refDir)
+	}
+
+	return refList, nil
+}
+
+func (backend *filesystemBackend) UpdateRef(ctx context.Context, refName string, pointer string) error {
+	path := filepath.Join(backend.root, "refs", refName)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return hierr.Errorf(err, "can't create directory for ref %s", refName)
+	}
+
+	err = ioutil.WriteFile(path, []byte(pointer), 0644)
+	if err != nil {
+		return hierr.Errorf(err, "can't write ref %s to filesystem backend", refName)
+	}
+
+	return nil
+}
+
+func (backend *filesystemBackend) RemoveRef(ctx context.Context, refName string) error {
+	err := os.Remove(filepath.Join(backend.root, "refs", refName))
+	if err != nil && !os.IsNotExist(err) {
+		return hierr.Errorf(err, "can't remove ref %s from filesystem backend", refName)
+	}
+
+	return nil
+}
+
+// Sync is a no-op: the filesystem backend reads and writes its root
+// directory directly, so there's nothing to reconcile.
+func (backend *filesystemBackend) Sync(ctx context.Context) error {
+	return nil
+}
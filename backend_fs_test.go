@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFilesystemBackendRoundTrip(t *testing.T) {
+	backend, err := NewBackend(t.TempDir(), "sha1")
+	if err != nil {
+		t.Fatalf("NewBackend: %s", err)
+	}
+
+	ctx := context.Background()
+
+	hash, err := backend.WriteObject(ctx, []byte("hello, carcosa"))
+	if err != nil {
+		t.Fatalf("WriteObject: %s", err)
+	}
+
+	err = validateHash(hash)
+	if err != nil {
+		t.Fatalf("WriteObject returned an invalid hash: %s", err)
+	}
+
+	data, err := backend.CatFile(ctx, hash)
+	if err != nil {
+		t.Fatalf("CatFile: %s", err)
+	}
+
+	if string(data) != "hello, carcosa" {
+		t.Fatalf("expected %q, got %q", "hello, carcosa", data)
+	}
+
+	err = backend.UpdateRef(ctx, "refs/secrets/prod/db-password", hash)
+	if err != nil {
+		t.Fatalf("UpdateRef: %s", err)
+	}
+
+	refList, err := backend.ListRefs(ctx, "refs/secrets/prod")
+	if err != nil {
+		t.Fatalf("ListRefs: %s", err)
+	}
+
+	if len(refList) != 1 || refList[0].hash != hash {
+		t.Fatalf("expected one ref pointing at %s, got %+v", hash, refList)
+	}
+
+	err = backend.RemoveRef(ctx, "refs/secrets/prod/db-password")
+	if err != nil {
+		t.Fatalf("RemoveRef: %s", err)
+	}
+
+	refList, err = backend.ListRefs(ctx, "refs/secrets/prod")
+	if err != nil {
+		t.Fatalf("ListRefs after RemoveRef: %s", err)
+	}
+
+	if len(refList) != 0 {
+		t.Fatalf("expected no refs after RemoveRef, got %+v", refList)
+	}
+
+	err = backend.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+}
+
+func TestHashContentMatchesObjectFormat(t *testing.T) {
+	data := []byte("hello, carcosa")
+
+	sha1Hash := hashContent("sha1", data)
+	err := validateHash(sha1Hash)
+	if err != nil || len(sha1Hash) != SHA1HexSize {
+		t.Fatalf("expected a %d-char sha1 hash, got %q (err: %v)", SHA1HexSize, sha1Hash, err)
+	}
+
+	sha256Hash := hashContent("sha256", data)
+	err = validateHash(sha256Hash)
+	if err != nil || len(sha256Hash) != SHA256HexSize {
+		t.Fatalf("expected a %d-char sha256 hash, got %q (err: %v)", SHA256HexSize, sha256Hash, err)
+	}
+
+	if sha1Hash == sha256Hash {
+		t.Fatalf("expected sha1 and sha256 hashes of the same content to differ")
+	}
+
+	// "hello world" (no trailing newline) hashes to
+	// 95d09f2b10159347eece71399a7e2e907ea3df4f via `git hash-object --stdin`;
+	// hashContent must match it exactly, not just produce a correctly-sized
+	// hash.
+	want := "95d09f2b10159347eece71399a7e2e907ea3df4f"
+	if got := hashContent("sha1", []byte("hello world")); got != want {
+		t.Fatalf("expected hashContent to match git hash-object, got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/reconquest/hierr-go"
+	"google.golang.org/api/iterator"
+)
+
+// gcsBackend mirrors s3Backend's object/ref layout but against a GCS
+// bucket, e.g. `--store gs://bucket/prefix`.
+type gcsBackend struct {
+	client       *storage.Client
+	bucket       string
+	prefix       string
+	objectFormat string
+}
+
+func newGCSBackend(location string, objectFormat string) (Backend, error) {
+	bucket, prefix := splitBucketPath(location)
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't create GCS client")
+	}
+
+	return &gcsBackend{
+		client:       client,
+		bucket:       bucket,
+		prefix:       prefix,
+		objectFormat: objectFormat,
+	}, nil
+}
+
+func (backend *gcsBackend) key(parts ...string) string {
+	return strings.Trim(strings.Join(append([]string{backend.prefix}, parts...), "/"), "/")
+}
+
+func (backend *gcsBackend) object(key string) *storage.ObjectHandle {
+	return backend.client.Bucket(backend.bucket).Object(key)
+}
+
+func (backend *gcsBackend) WriteObject(ctx context.Context, data []byte) (string, error) {
+	hash := hashContent(backend.objectFormat, data)
+
+	writer := backend.object(backend.key("objects", hash)).NewWriter(ctx)
+
+	_, err := writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return "", hierr.Errorf(err, "can't write object %s to gs://%s", hash, backend.bucket)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return "", hierr.Errorf(err, "can't finalize object %s in gs://%s", hash, backend.bucket)
+	}
+
+	return hash, nil
+}
+
+func (backend *gcsBackend) CatFile(ctx context.Context, hash string) ([]byte, error) {
+	reader, err := backend.object(backend.key("objects", hash)).NewReader(ctx)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read object %s from gs://%s", hash, backend.bucket)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read object %s body", hash)
+	}
+
+	return data, nil
+}
+
+func (backend *gcsBackend) ListRefs(ctx context.Context, namespace string) (refs, error) {
+	refList := refs{}
+
+	// Trailing slash keeps the match on a path-segment boundary -- without
+	// it, namespace "prod" would also match keys under "production/...".
+	prefix := backend.key("refs", namespace) + "/"
+
+	it := backend.client.Bucket(backend.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, hierr.Errorf(err, "can't list refs under gs://%s/%s", backend.bucket, prefix)
+		}
+
+		reader, err := backend.object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			continue
+		}
+
+		refList = append(refList, ref{
+			name: strings.TrimPrefix(attrs.Name, backend.key("refs")+"/"),
+			hash: strings.TrimSpace(string(data)),
+			stat: nil,
+		})
+	}
+
+	return refList, nil
+}
+
+func (backend *gcsBackend) UpdateRef(ctx context.Context, refName string, pointer string) error {
+	writer := backend.object(backend.key("refs", refName)).NewWriter(ctx)
+
+	_, err := writer.Write([]byte(pointer))
+	if err != nil {
+		writer.Close()
+		return hierr.Errorf(err, "can't write ref %s to gs://%s", refName, backend.bucket)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return hierr.Errorf(err, "can't finalize ref %s in gs://%s", refName, backend.bucket)
+	}
+
+	return nil
+}
+
+func (backend *gcsBackend) RemoveRef(ctx context.Context, refName string) error {
+	err := backend.object(backend.key("refs", refName)).Delete(ctx)
+	if err != nil {
+		return hierr.Errorf(err, "can't delete ref %s from gs://%s", refName, backend.bucket)
+	}
+
+	return nil
+}
+
+// Sync is a no-op: every operation above talks to GCS directly, so there's
+// nothing buffered locally to reconcile.
+func (backend *gcsBackend) Sync(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/reconquest/hierr-go"
+)
+
+// s3Backend stores encrypted blobs and refs as objects under a bucket/prefix,
+// e.g. `--store s3://bucket/prefix`. Objects live at <prefix>/objects/<hash>
+// and refs at <prefix>/refs/<namespace>/<name>, mirroring the layout used by
+// filesystemBackend so the two stay easy to reason about side by side.
+type s3Backend struct {
+	client       *s3.S3
+	bucket       string
+	prefix       string
+	objectFormat string
+}
+
+func newS3Backend(location string, objectFormat string) (Backend, error) {
+	bucket, prefix := splitBucketPath(location)
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't create S3 session")
+	}
+
+	return &s3Backend{
+		client:       s3.New(sess),
+		bucket:       bucket,
+		prefix:       prefix,
+		objectFormat: objectFormat,
+	}, nil
+}
+
+func splitBucketPath(location string) (string, string) {
+	parts := strings.SplitN(location, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+func (backend *s3Backend) key(parts ...string) string {
+	return strings.Trim(strings.Join(append([]string{backend.prefix}, parts...), "/"), "/")
+}
+
+func (backend *s3Backend) WriteObject(ctx context.Context, data []byte) (string, error) {
+	hash := hashContent(backend.objectFormat, data)
+
+	_, err := backend.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(backend.bucket),
+		Key:    aws.String(backend.key("objects", hash)),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", hierr.Errorf(err, "can't put object %s to s3://%s", hash, backend.bucket)
+	}
+
+	return hash, nil
+}
+
+func (backend *s3Backend) CatFile(ctx context.Context, hash string) ([]byte, error) {
+	output, err := backend.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(backend.bucket),
+		Key:    aws.String(backend.key("objects", hash)),
+	})
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't get object %s from s3://%s", hash, backend.bucket)
+	}
+	defer output.Body.Close()
+
+	data, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read object %s body", hash)
+	}
+
+	return data, nil
+}
+
+func (backend *s3Backend) ListRefs(ctx context.Context, namespace string) (refs, error) {
+	refList := refs{}
+
+	// Trailing slash keeps the match on a path-segment boundary -- without
+	// it, namespace "prod" would also match keys under "production/...".
+	prefix := backend.key("refs", namespace) + "/"
+
+	err := backend.client.ListObjectsV2PagesWithContext(
+		ctx,
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(backend.bucket),
+			Prefix: aws.String(prefix),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				output, err := backend.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+					Bucket: aws.String(backend.bucket),
+					Key:    object.Key,
+				})
+				if err != nil {
+					continue
+				}
+
+				data, err := ioutil.ReadAll(output.Body)
+				output.Body.Close()
+				if err != nil {
+					continue
+				}
+
+				name := strings.TrimPrefix(*object.Key, backend.key("refs")+"/")
+
+				refList = append(refList, ref{
+					name: name,
+					hash: strings.TrimSpace(string(data)),
+					stat: nil,
+				})
+			}
+
+			return true
+		},
+	)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't list refs under s3://%s/%s", backend.bucket, prefix)
+	}
+
+	return refList, nil
+}
+
+func (backend *s3Backend) UpdateRef(ctx context.Context, refName string, pointer string) error {
+	_, err := backend.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(backend.bucket),
+		Key:    aws.String(backend.key("refs", refName)),
+		Body:   bytes.NewReader([]byte(pointer)),
+	})
+	if err != nil {
+		return hierr.Errorf(err, "can't put ref %s to s3://%s", refName, backend.bucket)
+	}
+
+	return nil
+}
+
+func (backend *s3Backend) RemoveRef(ctx context.Context, refName string) error {
+	_, err := backend.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(backend.bucket),
+		Key:    aws.String(backend.key("refs", refName)),
+	})
+	if err != nil {
+		return hierr.Errorf(err, "can't delete ref %s from s3://%s", refName, backend.bucket)
+	}
+
+	return nil
+}
+
+// Sync is a no-op: every operation above talks to S3 directly, so there's
+// nothing buffered locally to reconcile.
+func (backend *s3Backend) Sync(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/hierr-go"
+)
+
+// missingObjectError distinguishes "git cat-file --batch reported this
+// object as missing" from an actual session/protocol failure: the former
+// leaves the batch session in sync (a well-formed header was still read)
+// and the rest of the batch can proceed, while the latter can't be trusted
+// to resync and must abort the whole session.
+type missingObjectError struct {
+	hash string
+}
+
+func (err *missingObjectError) Error() string {
+	return fmt.Sprintf("object %s is missing", err.hash)
+}
+
+// catFileBatchSession wraps a long-lived `git cat-file --batch` process so
+// reading many objects costs one process spawn instead of one per object.
+type catFileBatchSession struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (repo *git) newCatFileBatchSession(ctx context.Context) (*catFileBatchSession, error) {
+	cmd := exec.CommandContext(ctx, "git", "-C", repo.path, "cat-file", "--batch")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't get stdin for git cat-file --batch")
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't get stdout for git cat-file --batch")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't run git cat-file --batch")
+	}
+
+	return &catFileBatchSession{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// read requests a single object and blocks until its `<sha> <type>
+// <size>\n<content>\n` response has been parsed off stdout.
+func (session *catFileBatchSession) read(hash string) ([]byte, error) {
+	_, err := fmt.Fprintln(session.stdin, hash)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't request object %s from git cat-file --batch", hash)
+	}
+
+	header, err := session.stdout.ReadString('\n')
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read git cat-file --batch header for %s", hash)
+	}
+
+	size, err := parseCatFileBatchHeader(header)
+	if err != nil {
+		var missing *missingObjectError
+		if errors.As(err, &missing) {
+			// Stream stays in sync: a well-formed "<sha> missing" header
+			// was still consumed, just not a content body. Return the
+			// sentinel unwrapped so catFileBatch can tell this apart from
+			// a real session failure and keep going.
+			return nil, missing
+		}
+
+		return nil, hierr.Errorf(err, "can't parse git cat-file --batch header for %s", hash)
+	}
+
+	content := make([]byte, size)
+
+	_, err = io.ReadFull(session.stdout, content)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read git cat-file --batch content for %s", hash)
+	}
+
+	_, err = session.stdout.Discard(1)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read git cat-file --batch trailer for %s", hash)
+	}
+
+	return content, nil
+}
+
+// parseCatFileBatchHeader parses one line of `git cat-file --batch` output,
+// which is either `<sha> <type> <size>\n` for an object that exists, or
+// `<sha> missing\n` for one that doesn't, and returns the content length to
+// read next.
+func parseCatFileBatchHeader(header string) (int, error) {
+	fields := strings.Fields(header)
+
+	if len(fields) == 2 && fields[1] == "missing" {
+		return 0, &missingObjectError{hash: fields[0]}
+	}
+
+	if len(fields) != 3 {
+		return 0, fmt.Errorf("unexpected git cat-file --batch header: %q", strings.TrimSpace(header))
+	}
+
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, hierr.Errorf(err, "can't parse git cat-file --batch size")
+	}
+
+	return size, nil
+}
+
+func (session *catFileBatchSession) close() error {
+	err := session.stdin.Close()
+	if err != nil {
+		return hierr.Errorf(err, "can't close git cat-file --batch stdin")
+	}
+
+	err = session.cmd.Wait()
+	if err != nil {
+		return hierr.Errorf(err, "can't wait for git cat-file --batch")
+	}
+
+	return nil
+}
+
+// catFileBatch reads every hash in hashes through a single `git cat-file
+// --batch` process, rather than spawning `git cat-file -p` once per hash.
+// A hash git reports as missing is recorded in errs rather than aborting
+// the rest of the batch, since the batch protocol stays in sync across a
+// "missing" response; any other failure (a broken pipe, a malformed
+// header) can't be trusted to resync and aborts the whole batch.
+func (repo *git) catFileBatch(ctx context.Context, hashes []string) (map[string][]byte, map[string]error, error) {
+	for _, hash := range hashes {
+		err := validateHash(hash)
+		if err != nil {
+			return nil, nil, hierr.Errorf(err, "can't batch cat-file an invalid hash")
+		}
+	}
+
+	session, err := repo.newCatFileBatchSession(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contents := map[string][]byte{}
+	errs := map[string]error{}
+
+	for _, hash := range hashes {
+		data, err := session.read(hash)
+		if err != nil {
+			var missing *missingObjectError
+			if errors.As(err, &missing) {
+				errs[hash] = missing
+				continue
+			}
+
+			session.close()
+			return nil, nil, err
+		}
+
+		contents[hash] = data
+	}
+
+	err = session.close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return contents, errs, nil
+}
+
+// writeObjectBatch writes every blob in blobs via a single `git hash-object
+// -w --stdin-paths` process, rather than spawning `git hash-object -w
+// --stdin` once per blob. Each blob is staged to a temp file first, since
+// --stdin-paths reads a list of paths (not content) from stdin.
+func (repo *git) writeObjectBatch(ctx context.Context, blobs [][]byte) ([]string, error) {
+	dir, err := ioutil.TempDir("", "carcosa-batch")
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't create temp dir for batched hash-object")
+	}
+	defer os.RemoveAll(dir)
+
+	paths := make([]string, len(blobs))
+	for i, data := range blobs {
+		path := filepath.Join(dir, strconv.Itoa(i))
+
+		err := ioutil.WriteFile(path, data, 0600)
+		if err != nil {
+			return nil, hierr.Errorf(err, "can't write temp blob %d for batched hash-object", i)
+		}
+
+		paths[i] = path
+	}
+
+	cmd := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "hash-object", "-w", "--stdin-paths",
+	)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't get stdin for git hash-object --stdin-paths")
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't get stdout for git hash-object --stdin-paths")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't run git hash-object --stdin-paths")
+	}
+
+	go func() {
+		for _, path := range paths {
+			fmt.Fprintln(stdin, path)
+		}
+
+		stdin.Close()
+	}()
+
+	output, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read git hash-object --stdin-paths result")
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't wait for git hash-object --stdin-paths")
+	}
+
+	hashes := strings.Fields(string(output))
+	if len(hashes) != len(blobs) {
+		return nil, fmt.Errorf(
+			"expected %d hashes from git hash-object --stdin-paths, got %d",
+			len(blobs), len(hashes),
+		)
+	}
+
+	for _, hash := range hashes {
+		err := validateHash(hash)
+		if err != nil {
+			return nil, hierr.Errorf(err, "git hash-object --stdin-paths returned an invalid hash")
+		}
+	}
+
+	return hashes, nil
+}
+
+// readNamespace lists every ref under namespace and reads all of their
+// blobs through a single batched cat-file session, so a `list`/`sync` over
+// hundreds of secrets costs one process pair rather than hundreds. A blob
+// that git reports as missing behind one ref is reported in errs rather
+// than failing the whole namespace read, so one missing secret doesn't take
+// down `list`/`sync` for every other secret in the namespace; any other
+// cat-file failure still fails the whole read (see catFileBatch).
+func (repo *git) readNamespace(
+	ctx context.Context, namespace string,
+) (refs, map[string][]byte, map[string]error, error) {
+	refList, err := repo.listRefs(ctx, namespace)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	hashes := make([]string, len(refList))
+	for i, item := range refList {
+		hashes[i] = item.hash
+	}
+
+	contents, errs, err := repo.catFileBatch(ctx, hashes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return refList, contents, errs, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseCatFileBatchHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		size    int
+		wantErr bool
+	}{
+		{
+			name:   "present",
+			header: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 blob 0\n",
+			size:   0,
+		},
+		{
+			name:   "present with content size",
+			header: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 blob 1234\n",
+			size:   1234,
+		},
+		{
+			name:    "missing",
+			header:  "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 missing\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			header:  "garbage\n",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric size",
+			header:  "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 blob notasize\n",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			size, err := parseCatFileBatchHeader(test.header)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got size=%d", size)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if size != test.size {
+				t.Fatalf("expected size %d, got %d", test.size, size)
+			}
+		})
+	}
+}
+
+// TestParseCatFileBatchHeaderMissingIsTyped guards the distinction
+// catFileBatch relies on to keep one missing secret from aborting a whole
+// namespace read: a "missing" header must come back as a *missingObjectError,
+// not just any error, so it can be told apart from a real session failure.
+func TestParseCatFileBatchHeaderMissingIsTyped(t *testing.T) {
+	_, err := parseCatFileBatchHeader("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 missing\n")
+
+	var missing *missingObjectError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected a *missingObjectError, got %T: %v", err, err)
+	}
+}
+
+// TestCatFileBatchToleratesOneMissingHash guards against a regression to
+// catFileBatch aborting the whole batch the moment one hash comes back
+// missing: a namespace with hundreds of secrets shouldn't lose all of them
+// because one ref points at a missing object.
+func TestCatFileBatchToleratesOneMissingHash(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, out)
+		}
+	}
+
+	run("init", "--quiet")
+
+	hashObject := exec.Command("git", "-C", dir, "hash-object", "-w", "--stdin")
+	hashObject.Stdin = strings.NewReader("a real blob")
+	out, err := hashObject.Output()
+	if err != nil {
+		t.Fatalf("git hash-object: %s", err)
+	}
+	present := strings.TrimSpace(string(out))
+
+	missingHash := "0123456789abcdef0123456789abcdef01234567"
+
+	repo := &git{path: dir}
+
+	contents, errs, err := repo.catFileBatch(context.Background(), []string{present, missingHash})
+	if err != nil {
+		t.Fatalf("catFileBatch: %s", err)
+	}
+
+	if string(contents[present]) != "a real blob" {
+		t.Fatalf("expected the present hash's content to still be read, got %q", contents[present])
+	}
+
+	if _, ok := errs[missingHash]; !ok {
+		t.Fatalf("expected the missing hash to be reported in errs, got %+v", errs)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestContextWithTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	bounded, cancel := contextWithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if _, ok := bounded.Deadline(); !ok {
+		t.Fatalf("expected a deadline when timeout is positive")
+	}
+
+	unbounded, cancel := contextWithTimeout(ctx, 0)
+	defer cancel()
+
+	if _, ok := unbounded.Deadline(); ok {
+		t.Fatalf("expected no deadline when timeout is zero")
+	}
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/reconquest/hierr-go"
 )
@@ -18,8 +19,109 @@ const (
 	PushNoPrune = false
 )
 
+const (
+	// SHA1HexSize is the length in hex characters of a SHA-1 object ID.
+	SHA1HexSize = 40
+
+	// SHA256HexSize is the length in hex characters of a SHA-256 object
+	// ID, used by repositories created with `extensions.objectFormat =
+	// sha256`.
+	SHA256HexSize = 64
+)
+
+// ObjectIDLengths enumerates the hex lengths of object IDs carcosa knows how
+// to handle, used to validate hashes parsed out of git command output.
+var ObjectIDLengths = []int{SHA1HexSize, SHA256HexSize}
+
 type git struct {
-	path string
+	path         string
+	odb          objectDatabase
+	objectFormat string
+	hashSize     int
+}
+
+// objectDatabase is satisfied by a native Go object database implementation
+// (see odb_native.go) that lets *git skip exec()'ing the git binary for
+// object reads/writes and ref updates. When no implementation is available
+// (odb is nil, or it failed to open), *git falls back to shelling out.
+type objectDatabase interface {
+	WriteBlob(data []byte) (string, error)
+	CatBlob(hash string) ([]byte, error)
+	ListRefs(namespace string) (refs, error)
+	UpdateRef(name string, hash string) error
+	RemoveRef(name string) error
+	Close() error
+}
+
+func newGit(path string) *git {
+	repo := &git{
+		path:         path,
+		objectFormat: detectObjectFormat(path),
+	}
+
+	switch repo.objectFormat {
+	case "sha256":
+		repo.hashSize = SHA256HexSize
+	default:
+		repo.hashSize = SHA1HexSize
+	}
+
+	odb, err := openNativeODB(path, repo.objectFormat)
+	if err == nil {
+		repo.odb = odb
+	}
+
+	return repo
+}
+
+// detectObjectFormat inspects the repository's `extensions.objectFormat`
+// setting so carcosa knows whether it's talking to a SHA-1 or a SHA-256
+// repository. Repositories with no such extension (the vast majority,
+// today) are assumed to be SHA-1.
+func detectObjectFormat(path string) string {
+	output, err := exec.Command(
+		"git", "-C", path, "rev-parse", "--show-object-format",
+	).CombinedOutput()
+	if err != nil {
+		return "sha1"
+	}
+
+	format := strings.TrimSpace(string(output))
+	if format == "" {
+		return "sha1"
+	}
+
+	return format
+}
+
+// validateHash makes sure a hash parsed out of git command output has a
+// length carcosa actually knows how to handle, rather than silently
+// truncating or mishandling an unexpected object ID format down the line.
+func validateHash(hash string) error {
+	for _, length := range ObjectIDLengths {
+		if len(hash) == length {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"unexpected object hash length %d for %q, expected one of %v",
+		len(hash), hash, ObjectIDLengths,
+	)
+}
+
+// contextWithTimeout bounds ctx by timeout, if timeout is positive; a zero
+// or negative timeout returns ctx unchanged. clone, fetch and push use it so
+// a hung remote can't stall carcosa forever. Nothing in this package defines
+// a --timeout flag: callers pass the timeout to clone/fetch/push directly.
+func contextWithTimeout(
+	ctx context.Context, timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
 }
 
 type ref struct {
@@ -39,24 +141,35 @@ func (refs refs) Swap(i, j int) {
 }
 
 func (refs refs) Less(i, j int) bool {
-	if refs[i].stat == nil {
-		panic(
-			fmt.Sprintf("ref %s stat is nil", refs[i].hash),
-		)
-	}
+	return refModTime(refs[i]).Unix() < refModTime(refs[j]).Unix()
+}
 
-	if refs[j].stat == nil {
-		panic(
-			fmt.Sprintf("ref %s stat is nil", refs[j].hash),
-		)
+// refModTime returns a ref's on-disk modification time, or the zero time
+// for refs with no backing file to stat() -- e.g. ones returned by
+// listRemoteRefs, which only knows the hash and name a remote advertised.
+func refModTime(r ref) time.Time {
+	if r.stat == nil {
+		return time.Time{}
 	}
 
-	return refs[i].stat.ModTime().Unix() < refs[j].stat.ModTime().Unix()
+	return r.stat.ModTime()
 }
 
-func (repo *git) updateRef(refName string, pointer string) error {
-	output, err := exec.Command(
-		"git", "-C", repo.path, "update-ref", refName, pointer,
+func (repo *git) updateRef(ctx context.Context, refName string, pointer string) error {
+	err := validateHash(pointer)
+	if err != nil {
+		return hierr.Errorf(err, "can't update-ref with an invalid hash")
+	}
+
+	if repo.odb != nil {
+		err := repo.odb.UpdateRef(refName, pointer)
+		if err == nil {
+			return nil
+		}
+	}
+
+	output, err := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "update-ref", refName, pointer,
 	).CombinedOutput()
 	if err != nil {
 		return hierr.Errorf(
@@ -68,9 +181,16 @@ func (repo *git) updateRef(refName string, pointer string) error {
 	return nil
 }
 
-func (repo *git) removeRef(refName string) error {
-	output, err := exec.Command(
-		"git", "-C", repo.path, "update-ref", "-d", refName,
+func (repo *git) removeRef(ctx context.Context, refName string) error {
+	if repo.odb != nil {
+		err := repo.odb.RemoveRef(refName)
+		if err == nil {
+			return nil
+		}
+	}
+
+	output, err := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "update-ref", "-d", refName,
 	).CombinedOutput()
 	if err != nil {
 		return hierr.Errorf(
@@ -82,58 +202,35 @@ func (repo *git) removeRef(refName string) error {
 	return nil
 }
 
-func (repo *git) writeObject(data []byte) (string, error) {
-	cmd := exec.Command(
-		"git", "-C", repo.path, "hash-object", "-w", "--stdin",
-	)
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return "", hierr.Errorf(err, "can't get stdin for git hash-object")
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", hierr.Errorf(err, "can't get stdout for git hash-object")
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		return "", hierr.Errorf(
-			err,
-			"can't run git hash-object",
-		)
-	}
-
-	_, err = stdin.Write(data)
-	if err != nil {
-		return "", hierr.Errorf(err, "can't write data to git hash-object")
+// writeObject is a thin wrapper around writeObjectBatch for the common case
+// of a single blob; see batch.go for the batched `hash-object --stdin-paths`
+// implementation it delegates to.
+func (repo *git) writeObject(ctx context.Context, data []byte) (string, error) {
+	if repo.odb != nil {
+		hash, err := repo.odb.WriteBlob(data)
+		if err == nil {
+			return hash, nil
+		}
 	}
 
-	err = stdin.Close()
+	hashes, err := repo.writeObjectBatch(ctx, [][]byte{data})
 	if err != nil {
-		return "", hierr.Errorf(err, "can't close git hash-object stdin")
+		return "", err
 	}
 
-	output, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return "", hierr.Errorf(
-			err,
-			"can't read git hash-object result",
-		)
-	}
+	return hashes[0], nil
+}
 
-	err = cmd.Wait()
-	if err != nil {
-		return "", hierr.Errorf(err, "can't wait for git hash-object")
+func (repo *git) listRefs(ctx context.Context, namespace string) (refs, error) {
+	if repo.odb != nil {
+		refList, err := repo.odb.ListRefs(namespace)
+		if err == nil {
+			return refList, nil
+		}
 	}
 
-	return strings.TrimSpace(string(output)), nil
-}
-
-func (repo *git) listRefs(namespace string) (refs, error) {
-	output, err := exec.Command(
-		"git", "-C", repo.path, "show-ref",
+	output, err := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "show-ref",
 	).CombinedOutput()
 	if err != nil {
 		return nil, hierr.Errorf(
@@ -152,6 +249,11 @@ func (repo *git) listRefs(namespace string) (refs, error) {
 			return nil, hierr.Errorf(err, "can't read from git show-ref")
 		}
 
+		err = validateHash(hash)
+		if err != nil {
+			return nil, hierr.Errorf(err, "can't read from git show-ref")
+		}
+
 		if !strings.HasPrefix(name, namespace) {
 			continue
 		}
@@ -171,6 +273,63 @@ func (repo *git) listRefs(namespace string) (refs, error) {
 	return refList, nil
 }
 
+// listRemoteRefs queries a remote's advertised refs via `git ls-remote`
+// instead of `clone`+`listRefs`, so callers like `carcosa list --remote` or
+// `sync` can see what's on a remote without fetching any objects. Since
+// there's no local loose-ref file behind these, every returned ref has a
+// nil stat; see refModTime for how sorting copes with that.
+func (repo *git) listRemoteRefs(ctx context.Context, remote string, namespace string) (refs, error) {
+	output, err := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "ls-remote", remote, namespace+"/*",
+	).CombinedOutput()
+	if err != nil {
+		return nil, hierr.Errorf(
+			err,
+			"error executing git ls-remote\n%s", bytes.TrimSpace(output),
+		)
+	}
+
+	refList := []ref{}
+	scanner := bufio.NewScanner(bytes.NewBuffer(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		parsed, err := parseLsRemoteLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		refList = append(refList, parsed)
+	}
+
+	return refList, nil
+}
+
+// parseLsRemoteLine parses one line of `git ls-remote` output, which is a
+// `<sha>\t<name>` pair, tolerating a trailing \r from remotes that speak
+// CRLF line endings. The parsed ref always has a nil stat; see
+// listRemoteRefs for why.
+func parseLsRemoteLine(line string) (ref, error) {
+	line = strings.TrimSuffix(line, "\r")
+
+	fields := strings.SplitN(line, "\t", 2)
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return ref{}, fmt.Errorf("unexpected git ls-remote line: %q", line)
+	}
+
+	hash := fields[0]
+
+	err := validateHash(hash)
+	if err != nil {
+		return ref{}, hierr.Errorf(err, "can't read from git ls-remote")
+	}
+
+	return ref{name: fields[1], hash: hash, stat: nil}, nil
+}
+
 func (repo *git) isGitRepo() bool {
 	err := exec.Command(
 		"git", "-C", repo.path, "rev-parse", "--git-dir",
@@ -182,9 +341,14 @@ func (repo *git) isGitRepo() bool {
 	return true
 }
 
-func (repo *git) clone(remote string) error {
-	cmd := exec.Command(
-		"git", "-C", repo.path, "clone", "--depth=1", "--bare", "-n", remote, repo.path,
+// clone is network-touching, so it takes a timeout in addition to ctx; a
+// timeout of 0 disables the bound.
+func (repo *git) clone(ctx context.Context, remote string, timeout time.Duration) error {
+	ctx, cancel := contextWithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "clone", "--depth=1", "--bare", "-n", remote, repo.path,
 	)
 
 	cmd.Stdout = os.Stdout
@@ -201,9 +365,13 @@ func (repo *git) clone(remote string) error {
 	return nil
 }
 
-func (repo *git) fetch(remote string, ref string) error {
-	cmd := exec.Command(
-		"git", "-C", repo.path, "fetch", remote, ref,
+// fetch is network-touching; see clone's comment on timeout.
+func (repo *git) fetch(ctx context.Context, remote string, ref string, timeout time.Duration) error {
+	ctx, cancel := contextWithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx, "git", "-C", repo.path, "fetch", remote, ref,
 	)
 
 	cmd.Stdout = os.Stdout
@@ -220,7 +388,13 @@ func (repo *git) fetch(remote string, ref string) error {
 	return nil
 }
 
-func (repo *git) push(remote string, ref string, prune bool) error {
+// push is network-touching; see clone's comment on timeout.
+func (repo *git) push(
+	ctx context.Context, remote string, ref string, prune bool, timeout time.Duration,
+) error {
+	ctx, cancel := contextWithTimeout(ctx, timeout)
+	defer cancel()
+
 	command := []string{
 		"git", "-C", repo.path, "push", remote, ref,
 	}
@@ -229,7 +403,7 @@ func (repo *git) push(remote string, ref string, prune bool) error {
 		command = append(command, "--prune")
 	}
 
-	cmd := exec.Command(command[0], command[1:]...)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -245,16 +419,30 @@ func (repo *git) push(remote string, ref string, prune bool) error {
 	return nil
 }
 
-func (repo *git) catFile(hash string) ([]byte, error) {
-	output, err := exec.Command(
-		"git", "-C", repo.path, "cat-file", "-p", hash,
-	).CombinedOutput()
+// catFile is a thin wrapper around catFileBatch for the common case of a
+// single hash; see batch.go for the batched `cat-file --batch`
+// implementation it delegates to.
+func (repo *git) catFile(ctx context.Context, hash string) ([]byte, error) {
+	if repo.odb != nil {
+		data, err := repo.odb.CatBlob(hash)
+		if err == nil {
+			return data, nil
+		}
+	}
+
+	err := validateHash(hash)
 	if err != nil {
-		return nil, hierr.Errorf(
-			err,
-			"error executing git cat-file\n%s", bytes.TrimSpace(output),
-		)
+		return nil, hierr.Errorf(err, "can't cat-file an invalid hash")
+	}
+
+	contents, errs, err := repo.catFileBatch(ctx, []string{hash})
+	if err != nil {
+		return nil, err
+	}
+
+	if err, failed := errs[hash]; failed {
+		return nil, hierr.Errorf(err, "can't cat-file %s", hash)
 	}
 
-	return output, nil
+	return contents[hash], nil
 }
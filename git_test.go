@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestValidateHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{name: "sha1", hash: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{
+			name: "sha256",
+			hash: "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813",
+		},
+		{name: "too short", hash: "deadbeef", wantErr: true},
+		{name: "empty", hash: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateHash(test.hash)
+
+			if test.wantErr && err == nil {
+				t.Fatalf("expected an error for hash %q", test.hash)
+			}
+
+			if !test.wantErr && err != nil {
+				t.Fatalf("unexpected error for hash %q: %s", test.hash, err)
+			}
+		})
+	}
+}
+
+func TestParseLsRemoteLine(t *testing.T) {
+	sha1 := "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
+	tests := []struct {
+		name     string
+		line     string
+		wantHash string
+		wantName string
+		wantErr  bool
+	}{
+		{
+			name:     "normal line",
+			line:     sha1 + "\trefs/secrets/prod/db-password",
+			wantHash: sha1,
+			wantName: "refs/secrets/prod/db-password",
+		},
+		{
+			name:     "trailing CRLF",
+			line:     sha1 + "\trefs/secrets/prod/db-password\r",
+			wantHash: sha1,
+			wantName: "refs/secrets/prod/db-password",
+		},
+		{name: "no tab", line: sha1 + " refs/secrets/prod/db-password", wantErr: true},
+		{name: "empty ref name", line: sha1 + "\t", wantErr: true},
+		{name: "empty hash", line: "\trefs/secrets/prod/db-password", wantErr: true},
+		{name: "invalid hash length", line: "deadbeef\trefs/secrets/prod/db-password", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := parseLsRemoteLine(test.line)
+
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for line %q, got %+v", test.line, parsed)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error for line %q: %s", test.line, err)
+			}
+
+			if parsed.hash != test.wantHash || parsed.name != test.wantName {
+				t.Fatalf(
+					"expected hash=%q name=%q, got hash=%q name=%q",
+					test.wantHash, test.wantName, parsed.hash, parsed.name,
+				)
+			}
+
+			if parsed.stat != nil {
+				t.Fatalf("expected a nil stat for a remote ref, got %+v", parsed.stat)
+			}
+		})
+	}
+}
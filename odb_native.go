@@ -0,0 +1,256 @@
+//go:build nativeodb
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/git-lfs/gitobj"
+	"github.com/reconquest/hierr-go"
+)
+
+func decodeHash(hash string, hashSize int) ([]byte, error) {
+	if len(hash) != hashSize {
+		return nil, fmt.Errorf(
+			"object hash %q has length %d, expected %d for object format",
+			hash, len(hash), hashSize,
+		)
+	}
+
+	sha, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, hierr.Errorf(err, "invalid object hash: %s", hash)
+	}
+
+	return sha, nil
+}
+
+// nativeODB backs objectDatabase with a directly-opened on-disk object
+// database (via git-lfs/gitobj) plus hand-rolled loose+packed ref parsing,
+// so carcosa can read and write secrets without exec()'ing the git binary.
+// It's opened with the same objectFormat *git detected, so the native path
+// addresses objects with the same hash algorithm as the exec fallback
+// rather than silently defaulting to SHA-1 underneath a SHA-256 repo.
+type nativeODB struct {
+	path         string
+	objectFormat string
+	hashSize     int
+	odb          *gitobj.ObjectDatabase
+}
+
+func openNativeODB(path string, objectFormat string) (objectDatabase, error) {
+	gitDir := filepath.Join(path, ".git")
+	if _, err := os.Stat(gitDir); err != nil {
+		gitDir = path
+	}
+
+	opts := []gitobj.Option{}
+	hashSize := SHA1HexSize
+	if objectFormat == "sha256" {
+		opts = append(opts, gitobj.WithHashAlgorithm(gitobj.SHA256))
+		hashSize = SHA256HexSize
+	}
+
+	odb, err := gitobj.FromFilesystem(filepath.Join(gitDir, "objects"), "", opts...)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't open native object database at %s", gitDir)
+	}
+
+	return &nativeODB{
+		path:         path,
+		objectFormat: objectFormat,
+		hashSize:     hashSize,
+		odb:          odb,
+	}, nil
+}
+
+func (n *nativeODB) gitDir() string {
+	gitDir := filepath.Join(n.path, ".git")
+	if _, err := os.Stat(gitDir); err != nil {
+		return n.path
+	}
+
+	return gitDir
+}
+
+func (n *nativeODB) WriteBlob(data []byte) (string, error) {
+	blob := &gitobj.Blob{
+		Contents: bytes.NewReader(data),
+		Size:     int64(len(data)),
+	}
+
+	sha, err := n.odb.WriteBlob(blob)
+	if err != nil {
+		return "", hierr.Errorf(err, "can't write blob to native object database")
+	}
+
+	hash := fmt.Sprintf("%x", sha)
+	if len(hash) != n.hashSize {
+		return "", fmt.Errorf(
+			"native object database wrote a %d-char hash, expected %d for object format %q",
+			len(hash), n.hashSize, n.objectFormat,
+		)
+	}
+
+	return hash, nil
+}
+
+func (n *nativeODB) CatBlob(hash string) ([]byte, error) {
+	sha, err := decodeHash(hash, n.hashSize)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := n.odb.Blob(sha)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read blob %s from native object database", hash)
+	}
+	defer blob.Close()
+
+	data, err := ioutil.ReadAll(blob.Contents)
+	if err != nil {
+		return nil, hierr.Errorf(err, "can't read blob %s contents", hash)
+	}
+
+	return data, nil
+}
+
+func (n *nativeODB) ListRefs(namespace string) (refs, error) {
+	entries := map[string]string{}
+
+	err := n.loadLooseRefs(filepath.Join(n.gitDir(), "refs"), entries)
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.loadPackedRefs(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	refList := refs{}
+	for name, hash := range entries {
+		if !strings.HasPrefix(name, namespace) {
+			continue
+		}
+
+		stat, err := os.Stat(filepath.Join(n.gitDir(), name))
+		if err != nil {
+			// packed refs have no loose file on disk; leave stat
+			// nil and let callers synthesize a mod-time.
+			stat = nil
+		}
+
+		refList = append(refList, ref{
+			name: name,
+			hash: hash,
+			stat: stat,
+		})
+	}
+
+	return refList, nil
+}
+
+func (n *nativeODB) loadLooseRefs(dir string, entries map[string]string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(n.gitDir(), path)
+		if err != nil {
+			return err
+		}
+
+		entries[filepath.ToSlash(rel)] = strings.TrimSpace(string(data))
+
+		return nil
+	})
+	if err != nil {
+		return hierr.Errorf(err, "can't walk loose refs in %s", dir)
+	}
+
+	return nil
+}
+
+func (n *nativeODB) loadPackedRefs(entries map[string]string) error {
+	path := filepath.Join(n.gitDir(), "packed-refs")
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return hierr.Errorf(err, "can't open packed-refs")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		if _, ok := entries[fields[1]]; !ok {
+			entries[fields[1]] = fields[0]
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (n *nativeODB) UpdateRef(name string, hash string) error {
+	path := filepath.Join(n.gitDir(), name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return hierr.Errorf(err, "can't create directory for ref %s", name)
+	}
+
+	err = ioutil.WriteFile(path, []byte(hash+"\n"), 0644)
+	if err != nil {
+		return hierr.Errorf(err, "can't write loose ref %s", name)
+	}
+
+	return nil
+}
+
+func (n *nativeODB) RemoveRef(name string) error {
+	path := filepath.Join(n.gitDir(), name)
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return hierr.Errorf(err, "can't remove loose ref %s", name)
+	}
+
+	return nil
+}
+
+func (n *nativeODB) Close() error {
+	return n.odb.Close()
+}
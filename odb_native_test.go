@@ -0,0 +1,47 @@
+//go:build nativeodb
+
+package main
+
+import "testing"
+
+func TestDecodeHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		hashSize int
+		wantErr  bool
+	}{
+		{name: "sha1", hash: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", hashSize: SHA1HexSize},
+		{
+			name:     "sha256",
+			hash:     "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813",
+			hashSize: SHA256HexSize,
+		},
+		{
+			name:     "wrong length for format",
+			hash:     "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+			hashSize: SHA256HexSize,
+			wantErr:  true,
+		},
+		{
+			name:     "not hex",
+			hash:     "zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz",
+			hashSize: SHA1HexSize,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeHash(test.hash, test.hashSize)
+
+			if test.wantErr && err == nil {
+				t.Fatalf("expected an error for hash %q at size %d", test.hash, test.hashSize)
+			}
+
+			if !test.wantErr && err != nil {
+				t.Fatalf("unexpected error for hash %q: %s", test.hash, err)
+			}
+		})
+	}
+}
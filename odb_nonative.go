@@ -0,0 +1,12 @@
+//go:build !nativeodb
+
+package main
+
+import "fmt"
+
+// openNativeODB is the stub used when carcosa is built without the
+// `nativeodb` build tag. It always fails to open so that *git transparently
+// falls back to shelling out to the git binary, exactly as before.
+func openNativeODB(path string, objectFormat string) (objectDatabase, error) {
+	return nil, fmt.Errorf("native object database support not built in; build with -tags nativeodb")
+}
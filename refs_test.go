@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func selfStat(t *testing.T) os.FileInfo {
+	t.Helper()
+
+	stat, err := os.Stat(".")
+	if err != nil {
+		t.Fatalf("can't stat() current directory: %s", err)
+	}
+
+	return stat
+}
+
+func TestRefModTime(t *testing.T) {
+	withStat := ref{name: "refs/heads/main", hash: "deadbeef", stat: selfStat(t)}
+	withoutStat := ref{name: "refs/heads/remote", hash: "beefdead", stat: nil}
+
+	if got := refModTime(withoutStat); !got.IsZero() {
+		t.Fatalf("expected zero time for a ref with nil stat, got %s", got)
+	}
+
+	if got := refModTime(withStat); got.IsZero() {
+		t.Fatalf("expected a non-zero time for a ref with stat")
+	}
+}
+
+// TestRefsSortToleratesNilStat guards against a regression to the panic
+// refs.Less used to raise on a nil stat -- the case listRemoteRefs produces,
+// since remote refs have no local loose-ref file to stat().
+func TestRefsSortToleratesNilStat(t *testing.T) {
+	list := refs{
+		{name: "refs/heads/a", hash: "a", stat: nil},
+		{name: "refs/heads/b", hash: "b", stat: selfStat(t)},
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("sorting refs with a nil stat should not panic, got: %v", r)
+		}
+	}()
+
+	_ = list.Less(0, 1)
+	_ = list.Less(1, 0)
+}